@@ -0,0 +1,29 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1alpha2
+
+// EtcdSnapshotS3 configures optional upload of etcd snapshots and their metadata to an
+// S3-compatible (AWS S3 or MinIO) bucket.
+type EtcdSnapshotS3 struct {
+	Endpoint  string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`
+	Bucket    string `yaml:"bucket,omitempty" json:"bucket,omitempty"`
+	Region    string `yaml:"region,omitempty" json:"region,omitempty"`
+	AccessKey string `yaml:"accessKey,omitempty" json:"accessKey,omitempty"`
+	SecretKey string `yaml:"secretKey,omitempty" json:"secretKey,omitempty"`
+	Insecure  bool   `yaml:"insecure,omitempty" json:"insecure,omitempty"`
+	Folder    string `yaml:"folder,omitempty" json:"folder,omitempty"`
+}
@@ -0,0 +1,34 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1alpha2
+
+// KubernetesSpec holds the subset of cluster-wide Kubernetes/etcd lifecycle configuration
+// consumed by pkg/etcd's snapshot, backup and restore actions.
+type KubernetesSpec struct {
+	Version             string `yaml:"version,omitempty" json:"version,omitempty"`
+	EtcdBackupDir       string `yaml:"etcdBackupDir,omitempty" json:"etcdBackupDir,omitempty"`
+	EtcdBackupScriptDir string `yaml:"etcdBackupScriptDir,omitempty" json:"etcdBackupScriptDir,omitempty"`
+	EtcdBackupPeriod    int    `yaml:"etcdBackupPeriod,omitempty" json:"etcdBackupPeriod,omitempty"`
+	KeepBackupNumber    int    `yaml:"keepBackupNumber,omitempty" json:"keepBackupNumber,omitempty"`
+
+	// EtcdBackupRetentionDuration additionally prunes snapshots older than this duration
+	// (e.g. "168h"), on top of the count-based KeepBackupNumber retention.
+	EtcdBackupRetentionDuration string `yaml:"etcdBackupRetentionDuration,omitempty" json:"etcdBackupRetentionDuration,omitempty"`
+	// EtcdSnapshotS3, when set, uploads every snapshot and its metadata JSON to an
+	// S3-compatible bucket after it is taken.
+	EtcdSnapshotS3 *EtcdSnapshotS3 `yaml:"etcdSnapshotS3,omitempty" json:"etcdSnapshotS3,omitempty"`
+}
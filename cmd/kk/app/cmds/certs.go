@@ -0,0 +1,95 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmds
+
+import (
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/pipelines"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdCerts returns the `kk certs` command group: bootstrap, renew and check-expiration for the
+// cluster's etcd certs.
+func NewCmdCerts() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "certs",
+		Short: "Manage certificates for a kubekey-installed cluster",
+	}
+	cmd.AddCommand(newCmdCertsBootstrap())
+	cmd.AddCommand(newCmdCertsRenew())
+	cmd.AddCommand(newCmdCertsCheckExpiration())
+	return cmd
+}
+
+func newCmdCertsBootstrap() *cobra.Command {
+	opt := &common.Argument{}
+	var etcdOnly bool
+	cmd := &cobra.Command{
+		Use:   "bootstrap",
+		Short: "Generate and distribute certificates for a new cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !etcdOnly {
+				return errors.New("certs bootstrap currently only supports --etcd")
+			}
+			runtime, err := common.NewKubeRuntime(common.AllInOne, *opt)
+			if err != nil {
+				return err
+			}
+			return pipelines.NewBootstrapETCDCertsPipeline(runtime)
+		},
+	}
+	cmd.Flags().BoolVar(&etcdOnly, "etcd", false, "bootstrap only the etcd certs")
+	return cmd
+}
+
+func newCmdCertsRenew() *cobra.Command {
+	opt := &common.Argument{}
+	var etcdOnly bool
+	cmd := &cobra.Command{
+		Use:   "renew",
+		Short: "Renew certificates that are within their expiration window",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !etcdOnly {
+				return errors.New("certs renew currently only supports --etcd")
+			}
+			runtime, err := common.NewKubeRuntime(common.AllInOne, *opt)
+			if err != nil {
+				return err
+			}
+			return pipelines.NewRenewETCDCertsPipeline(runtime)
+		},
+	}
+	cmd.Flags().BoolVar(&etcdOnly, "etcd", false, "renew only the etcd certs")
+	return cmd
+}
+
+func newCmdCertsCheckExpiration() *cobra.Command {
+	opt := &common.Argument{}
+	cmd := &cobra.Command{
+		Use:   "check-expiration",
+		Short: "Print the remaining validity of every etcd cert in the cluster",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runtime, err := common.NewKubeRuntime(common.AllInOne, *opt)
+			if err != nil {
+				return err
+			}
+			return pipelines.NewCheckETCDCertExpirationPipeline(runtime)
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,103 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cmds
+
+import (
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/pipelines"
+	"github.com/spf13/cobra"
+)
+
+// NewCmdEtcd returns the `kk etcd` command group: snapshot (which also prunes old snapshots) and
+// restore for the auditable backup pipeline, a status subcommand backed by the v3 health probe, and join for adding
+// learners to the cluster.
+func NewCmdEtcd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "etcd",
+		Short: "Manage the etcd cluster backing a kubekey-installed Kubernetes cluster",
+	}
+	cmd.AddCommand(newCmdEtcdSnapshot())
+	cmd.AddCommand(newCmdEtcdRestore())
+	cmd.AddCommand(newCmdEtcdStatus())
+	cmd.AddCommand(newCmdEtcdJoin())
+	return cmd
+}
+
+func newCmdEtcdJoin() *cobra.Command {
+	opt := &common.Argument{}
+	cmd := &cobra.Command{
+		Use:   "join",
+		Short: "Join every configured etcd host as a learner and promote it once caught up",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runtime, err := common.NewKubeRuntime(common.AllInOne, *opt)
+			if err != nil {
+				return err
+			}
+			return pipelines.NewAddETCDMemberPipeline(runtime)
+		},
+	}
+	return cmd
+}
+
+func newCmdEtcdStatus() *cobra.Command {
+	opt := &common.Argument{}
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Print a v3-API health report for every etcd member",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runtime, err := common.NewKubeRuntime(common.AllInOne, *opt)
+			if err != nil {
+				return err
+			}
+			return pipelines.NewStatusETCDPipeline(runtime)
+		},
+	}
+	return cmd
+}
+
+func newCmdEtcdSnapshot() *cobra.Command {
+	opt := &common.Argument{}
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Take a v3-API etcd snapshot on every member, and prune old ones",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runtime, err := common.NewKubeRuntime(common.AllInOne, *opt)
+			if err != nil {
+				return err
+			}
+			return pipelines.NewSnapshotETCDPipeline(runtime)
+		},
+	}
+	return cmd
+}
+
+func newCmdEtcdRestore() *cobra.Command {
+	opt := &common.Argument{}
+	cmd := &cobra.Command{
+		Use:   "restore <snapshot-name>",
+		Short: "Restore the etcd cluster from a named local or S3 snapshot",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runtime, err := common.NewKubeRuntime(common.AllInOne, *opt)
+			if err != nil {
+				return err
+			}
+			return pipelines.NewRestoreETCDPipeline(runtime, args[0])
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,28 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package common
+
+// ETCDSnapshotPath and ETCDSnapshotMetaPath are module cache keys under which SnapshotETCD
+// records the local paths of the snapshot it took and its companion metadata file.
+const (
+	ETCDSnapshotPath     = "etcdSnapshotPath"
+	ETCDSnapshotMetaPath = "etcdSnapshotMetaPath"
+)
+
+// ETCDHealthReport is the pipeline cache key under which HealthCheckV3 stores the
+// *healthpoll.EtcdHealthReport for PrintHealthReport (and `kk etcd status`) to read back.
+const ETCDHealthReport = "etcdHealthReport"
@@ -0,0 +1,134 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package etcd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/action"
+	"github.com/kubesphere/kubekey/pkg/core/connector"
+	"github.com/kubesphere/kubekey/pkg/core/util"
+	"github.com/kubesphere/kubekey/pkg/etcd/templates"
+	"github.com/pkg/errors"
+)
+
+// BackendType selects which datastore the pipeline installs and talks to behind the etcd gRPC
+// surface that kube-apiserver expects.
+type BackendType string
+
+const (
+	// BackendKubeKey is the existing behavior: kubekey installs and manages a binary etcd cluster.
+	BackendKubeKey BackendType = "kubekey"
+	// BackendExternal points at a user-supplied etcd endpoint set; kubekey skips installation
+	// but still health-checks and resolves access addresses against it.
+	BackendExternal BackendType = "external"
+	// BackendKine installs kine as a systemd unit fronting a SQL datastore, exposing the etcd
+	// gRPC surface on 2379 without running real etcd at all.
+	BackendKine BackendType = "kine"
+)
+
+// etcdBackend returns the configured backend type, defaulting to BackendKubeKey for clusters
+// that don't set Cluster.Etcd.Type.
+func etcdBackend(kubeConf *common.KubeConf) BackendType {
+	t := kubeConf.Cluster.Etcd.Type
+	if t == "" {
+		return BackendKubeKey
+	}
+	return BackendType(t)
+}
+
+// InstallKine renders and starts the kine systemd unit on a control-plane host, pointing it at
+// the configured SQL DSN and exposing the etcd gRPC surface on 2379.
+type InstallKine struct {
+	common.KubeAction
+}
+
+func (i *InstallKine) Execute(runtime connector.Runtime) error {
+	kine := i.KubeConf.Cluster.Etcd.Kine
+	if kine == nil || kine.DSN == "" {
+		return errors.New("Cluster.Etcd.Kine.DSN must be set to install the kine backend")
+	}
+
+	// hostCertRoles grants this Master host admin/member certs for exactly this reason: kine has
+	// no dedicated ETCD-role host, so it serves the gRPC surface off the same admin cert pair
+	// healthCheck already uses against it.
+	host := runtime.RemoteHost()
+	templateAction := action.Template{
+		Template: templates.KineService,
+		Dst:      filepath.Join("/etc/systemd/system", templates.KineService.Name()),
+		Data: util.Data{
+			"DSN":      kine.DSN,
+			"CertFile": filepath.Join(common.ETCDCertDir, fmt.Sprintf("admin-%s.pem", host.GetName())),
+			"KeyFile":  filepath.Join(common.ETCDCertDir, fmt.Sprintf("admin-%s-key.pem", host.GetName())),
+		},
+	}
+	templateAction.Init(nil, nil)
+	if err := templateAction.Execute(runtime); err != nil {
+		return errors.Wrap(errors.WithStack(err), "generate kine systemd unit failed")
+	}
+
+	if _, err := runtime.GetRunner().SudoCmd("systemctl daemon-reload && systemctl restart kine && systemctl enable kine", true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "start kine failed")
+	}
+	return nil
+}
+
+// generateAccessAddressForBackend resolves the access address list for non-kubekey backends:
+// external endpoints come straight from config, kine always listens locally on 2379.
+func generateAccessAddressForBackend(kubeConf *common.KubeConf, runtime connector.Runtime) (string, bool) {
+	switch etcdBackend(kubeConf) {
+	case BackendExternal:
+		return strings.Join(kubeConf.Cluster.Etcd.External.Endpoints, ","), true
+	case BackendKine:
+		var addrs []string
+		for _, host := range runtime.GetHostsByRole(common.Master) {
+			addrs = append(addrs, fmt.Sprintf("https://%s:2379", host.GetInternalAddress()))
+		}
+		return strings.Join(addrs, ","), true
+	default:
+		return "", false
+	}
+}
+
+// healthCheckForBackend runs the backend-appropriate health probe, returning handled=false when
+// the caller should fall back to the default binary-etcd health check.
+func healthCheckForBackend(kubeConf *common.KubeConf, runtime connector.Runtime, cluster *EtcdCluster) (handled bool, err error) {
+	switch etcdBackend(kubeConf) {
+	case BackendExternal, BackendKine:
+		return true, healthCheck(runtime, cluster)
+	default:
+		return false, nil
+	}
+}
+
+// restartForBackend restarts the backend-appropriate systemd unit, returning handled=false when
+// the caller should fall back to restarting etcd itself.
+func restartForBackend(kubeConf *common.KubeConf, runtime connector.Runtime) (handled bool, err error) {
+	switch etcdBackend(kubeConf) {
+	case BackendExternal:
+		// nothing to restart, the datastore is managed outside kubekey.
+		return true, nil
+	case BackendKine:
+		_, err := runtime.GetRunner().SudoCmd("systemctl daemon-reload && systemctl restart kine && systemctl enable kine", true)
+		return true, errors.Wrap(errors.WithStack(err), "restart kine failed")
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,287 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package etcd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/connector"
+	"github.com/kubesphere/kubekey/pkg/core/util"
+	"github.com/pkg/errors"
+)
+
+const (
+	// LocalCSRDir is the module cache key under which the local workdir's per-host CSR
+	// directory is stashed between CertSigner steps.
+	LocalCSRDir = "localCSRDir"
+	// LocalCAPath is the module cache key under which CertSigner stashes the local path it
+	// fetched the CA's public cert to, for DistributeSignedCerts to ship out to every host.
+	LocalCAPath = "localCAPath"
+	// certRole names the three cert roles a host can request, matching generateCertsFiles.
+	certRoleAdmin  = "admin"
+	certRoleMember = "member"
+	certRoleNode   = "node"
+)
+
+// CertSigner generates only the CA on the bootstrap node. It never touches any host's private
+// key: every other host generates its own key locally and only ships a CSR back here for
+// signing. Paired with GenerateHostKeyAndCSR/FetchCSRs/SignCSRs/DistributeSignedCerts below and
+// run via pipelines.NewBootstrapETCDCertsPipeline, this is the only cert-bootstrap path.
+type CertSigner struct {
+	common.KubeAction
+}
+
+func (c *CertSigner) Execute(runtime connector.Runtime) error {
+	caExists, err := runtime.GetRunner().FileExist(filepath.Join(common.ETCDCertDir, "ca.pem"))
+	if err != nil {
+		return err
+	}
+	if !caExists {
+		caCmd := fmt.Sprintf("chmod +x %[1]s/make-ssl-etcd.sh && /bin/bash -x %[1]s/make-ssl-etcd.sh -f %[1]s/openssl.conf -d %[1]s -ca-only",
+			common.ETCDCertDir)
+		if _, err := runtime.GetRunner().SudoCmd(caCmd, false); err != nil {
+			return errors.Wrap(errors.WithStack(err), "generate etcd CA failed")
+		}
+	}
+	// Fetch the CA's public cert locally even when it already existed (idempotent: re-running
+	// CertSigner for a scale-out add must not rotate the CA, but DistributeSignedCerts still
+	// needs a local copy to ship to any newly added host).
+
+	localCADir := filepath.Join(runtime.GetWorkDir(), "ETCD_ca")
+	if err := util.CreateDir(localCADir); err != nil {
+		return err
+	}
+	localCAPath := filepath.Join(localCADir, "ca.pem")
+	if err := runtime.GetRunner().Fetch(localCAPath, filepath.Join(common.ETCDCertDir, "ca.pem")); err != nil {
+		return errors.Wrap(errors.WithStack(err), "fetch etcd ca cert failed")
+	}
+	c.ModuleCache.Set(LocalCAPath, localCAPath)
+	return nil
+}
+
+// GenerateHostKeyAndCSR runs on every etcd/master host. It generates a private key that never
+// leaves the host, then produces a CSR with the SANs appropriate to the host's role(s) as
+// defined by generateCertsFiles.
+type GenerateHostKeyAndCSR struct {
+	common.KubeAction
+}
+
+func (g *GenerateHostKeyAndCSR) Execute(runtime connector.Runtime) error {
+	host := runtime.RemoteHost()
+	for _, role := range hostCertRoles(g.KubeConf, runtime, host) {
+		prefix := fmt.Sprintf("%s-%s", role, host.GetName())
+		keyPath := filepath.Join(common.ETCDCertDir, fmt.Sprintf("%s-key.pem", prefix))
+
+		certExists, err := runtime.GetRunner().FileExist(filepath.Join(common.ETCDCertDir, fmt.Sprintf("%s.pem", prefix)))
+		if err != nil {
+			return err
+		}
+		if certExists {
+			// missing-cert-only mode: this host already has a key/cert pair, skip it.
+			continue
+		}
+
+		keyExists, err := runtime.GetRunner().FileExist(keyPath)
+		if err != nil {
+			return err
+		}
+		if !keyExists {
+			genKeyCmd := fmt.Sprintf("openssl genrsa -out %s 2048", keyPath)
+			if _, err := runtime.GetRunner().SudoCmd(genKeyCmd, false); err != nil {
+				return errors.Wrap(errors.WithStack(err), fmt.Sprintf("generate %s key failed", prefix))
+			}
+		}
+
+		csrPath := filepath.Join(common.ETCDCertDir, fmt.Sprintf("%s.csr", prefix))
+		csrCmd := fmt.Sprintf("openssl req -new -key %s -subj '/CN=%s' -config %s/openssl.conf -reqexts %s_req -out %s",
+			keyPath, prefix, common.ETCDCertDir, role, csrPath)
+		if _, err := runtime.GetRunner().SudoCmd(csrCmd, false); err != nil {
+			return errors.Wrap(errors.WithStack(err), fmt.Sprintf("generate %s csr failed", prefix))
+		}
+	}
+	return nil
+}
+
+// hostCertRoles returns which of admin/member/node certs a host needs, mirroring
+// generateCertsFiles' per-role naming. kubeConf may be nil, which is treated the same as the
+// default BackendKubeKey backend.
+func hostCertRoles(kubeConf *common.KubeConf, runtime connector.Runtime, host connector.Host) []string {
+	var roles []string
+	isETCDHost := false
+	for _, h := range runtime.GetHostsByRole(common.ETCD) {
+		if h.GetName() == host.GetName() {
+			roles = append(roles, certRoleAdmin, certRoleMember)
+			isETCDHost = true
+		}
+	}
+
+	isMasterHost := false
+	for _, h := range runtime.GetHostsByRole(common.Master) {
+		if h.GetName() == host.GetName() {
+			roles = append(roles, certRoleNode)
+			isMasterHost = true
+		}
+	}
+
+	if !isETCDHost && isMasterHost && kubeConf != nil && etcdBackend(kubeConf) != BackendKubeKey {
+		// kine/external clusters expose the etcd-compatible endpoint on the control-plane
+		// nodes themselves; there is no dedicated ETCD-role host to own the admin/member
+		// client certs healthCheck and InstallKine expect, so the master host gets them too.
+		roles = append(roles, certRoleAdmin, certRoleMember)
+	}
+	return roles
+}
+
+// FetchCSRs pulls every pending CSR (and only the CSRs, never the accompanying key) from a
+// host's etcd cert directory back to the local workdir, from where SignCSRs ships it to the
+// bootstrap node for signing.
+type FetchCSRs struct {
+	common.KubeAction
+}
+
+func (f *FetchCSRs) Execute(runtime connector.Runtime) error {
+	host := runtime.RemoteHost()
+	localCSRDir := filepath.Join(runtime.GetWorkDir(), "ETCD_csr")
+	if err := util.CreateDir(localCSRDir); err != nil {
+		return err
+	}
+
+	for _, role := range hostCertRoles(f.KubeConf, runtime, host) {
+		prefix := fmt.Sprintf("%s-%s", role, host.GetName())
+		csrFile := fmt.Sprintf("%s.csr", prefix)
+		remoteCSRPath := filepath.Join(common.ETCDCertDir, csrFile)
+
+		// Gate on the same "does this host already have its cert" check GenerateHostKeyAndCSR
+		// uses for key generation: DistributeSignedCerts removes the remote CSR once it has
+		// shipped the signed cert back, but this check also protects a scale-out run where an
+		// older, already-provisioned host's CSR file was left behind for any other reason.
+		certExists, err := runtime.GetRunner().FileExist(filepath.Join(common.ETCDCertDir, fmt.Sprintf("%s.pem", prefix)))
+		if err != nil {
+			return err
+		}
+		if certExists {
+			continue
+		}
+
+		csrExists, err := runtime.GetRunner().FileExist(remoteCSRPath)
+		if err != nil {
+			return err
+		}
+		if !csrExists {
+			// not generated yet, e.g. GenerateHostKeyAndCSR hasn't run for this host/role.
+			continue
+		}
+		if err := runtime.GetRunner().Fetch(filepath.Join(localCSRDir, csrFile), remoteCSRPath); err != nil {
+			return errors.Wrap(errors.WithStack(err), "fetch etcd csr failed")
+		}
+	}
+
+	f.ModuleCache.Set(LocalCSRDir, localCSRDir)
+	return nil
+}
+
+// SignCSRs copies every fetched CSR to the bootstrap node, signs it there against the CA and
+// fetches the signed cert back into the local CSR directory. It never sees or generates a private
+// key for the requesting host.
+type SignCSRs struct {
+	common.KubeAction
+}
+
+func (s *SignCSRs) Execute(runtime connector.Runtime) error {
+	v, ok := s.ModuleCache.Get(LocalCSRDir)
+	if !ok {
+		return errors.New("get etcd local csr dir by module cache failed")
+	}
+	localCSRDir := v.(string)
+
+	entries, err := util.ListDir(localCSRDir)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), "list etcd csr dir failed")
+	}
+
+	for _, csrFile := range entries {
+		if filepath.Ext(csrFile) != ".csr" {
+			// signed certs from earlier runs share this directory.
+			continue
+		}
+		prefix := csrFile[:len(csrFile)-len(".csr")]
+		certFile := fmt.Sprintf("%s.pem", prefix)
+		remoteCSRPath := filepath.Join(common.TmpDir, csrFile)
+		remoteCertPath := filepath.Join(common.TmpDir, certFile)
+		if err := runtime.GetRunner().SudoScp(filepath.Join(localCSRDir, csrFile), remoteCSRPath); err != nil {
+			return errors.Wrap(errors.WithStack(err), fmt.Sprintf("scp etcd csr %s to the bootstrap node failed", csrFile))
+		}
+		signCmd := fmt.Sprintf("openssl x509 -req -in %s -CA %s/ca.pem -CAkey %s/ca-key.pem -CAcreateserial "+
+			"-out %s -days 3650 -extfile %s/openssl.conf",
+			remoteCSRPath, common.ETCDCertDir, common.ETCDCertDir,
+			remoteCertPath, common.ETCDCertDir)
+		if _, err := runtime.GetRunner().SudoCmd(signCmd, false); err != nil {
+			return errors.Wrap(errors.WithStack(err), fmt.Sprintf("sign etcd csr %s failed", csrFile))
+		}
+		if err := runtime.GetRunner().Fetch(filepath.Join(localCSRDir, certFile), remoteCertPath); err != nil {
+			return errors.Wrap(errors.WithStack(err), fmt.Sprintf("fetch signed etcd cert %s failed", certFile))
+		}
+	}
+	return nil
+}
+
+// DistributeSignedCerts scps each host's signed cert back to it, and only the signed cert: the
+// matching private key was generated on, and never leaves, the host it belongs to. It also
+// ships the CA's public cert to every host, since every etcdctl invocation in this package
+// (ETCDCTL_CACERT) needs ca.pem present locally to trust the other side of the connection, and
+// removes each CSR once its cert has been delivered so a later scale-out run only re-processes
+// the new member, not every previously provisioned host.
+type DistributeSignedCerts struct {
+	common.KubeAction
+}
+
+func (d *DistributeSignedCerts) Execute(runtime connector.Runtime) error {
+	host := runtime.RemoteHost()
+	v, ok := d.ModuleCache.Get(LocalCSRDir)
+	if !ok {
+		return errors.New("get etcd local csr dir by module cache failed")
+	}
+	localCSRDir := v.(string)
+
+	if caPath, ok := d.ModuleCache.Get(LocalCAPath); ok {
+		if err := runtime.GetRunner().SudoScp(caPath.(string), filepath.Join(common.ETCDCertDir, "ca.pem")); err != nil {
+			return errors.Wrap(errors.WithStack(err), "scp etcd ca cert failed")
+		}
+	}
+
+	for _, role := range hostCertRoles(d.KubeConf, runtime, host) {
+		prefix := fmt.Sprintf("%s-%s", role, host.GetName())
+		certFile := fmt.Sprintf("%s.pem", prefix)
+		localPath := filepath.Join(localCSRDir, certFile)
+
+		exists, err := util.FileExist(localPath)
+		if err != nil || !exists {
+			continue
+		}
+		if err := runtime.GetRunner().SudoScp(localPath, filepath.Join(common.ETCDCertDir, certFile)); err != nil {
+			return errors.Wrap(errors.WithStack(err), "scp signed etcd cert failed")
+		}
+
+		csrPath := filepath.Join(common.ETCDCertDir, fmt.Sprintf("%s.csr", prefix))
+		if _, err := runtime.GetRunner().SudoCmd(fmt.Sprintf("rm -f %s", csrPath), false); err != nil {
+			return errors.Wrap(errors.WithStack(err), "remove distributed etcd csr failed")
+		}
+	}
+	return nil
+}
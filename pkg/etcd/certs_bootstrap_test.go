@@ -0,0 +1,153 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package etcd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/connector"
+)
+
+type fakeHost struct {
+	connector.Host
+	name string
+}
+
+func (f *fakeHost) GetName() string { return f.name }
+
+type fakeRuntime struct {
+	connector.Runtime
+	etcdHosts   []connector.Host
+	masterHosts []connector.Host
+	remote      connector.Host
+}
+
+func (f *fakeRuntime) GetHostsByRole(role string) []connector.Host {
+	switch role {
+	case common.ETCD:
+		return f.etcdHosts
+	case common.Master:
+		return f.masterHosts
+	default:
+		return nil
+	}
+}
+
+func (f *fakeRuntime) RemoteHost() connector.Host { return f.remote }
+
+// csrFileName mirrors how FetchCSRs derives the remote CSR file name for a given role/host, so
+// tests can assert about what FetchCSRs would fetch without needing a full runner fake.
+func csrFileName(role, hostName string) string {
+	return fmt.Sprintf("%s-%s.csr", role, hostName)
+}
+
+func TestHostCertRolesOnlyGrantsOwnedRoles(t *testing.T) {
+	etcd1 := &fakeHost{name: "etcd1"}
+	master1 := &fakeHost{name: "master1"}
+	both := &fakeHost{name: "both1"}
+	neither := &fakeHost{name: "edge1"}
+
+	runtime := &fakeRuntime{
+		etcdHosts:   []connector.Host{etcd1, both},
+		masterHosts: []connector.Host{master1, both},
+	}
+
+	cases := []struct {
+		host  connector.Host
+		roles []string
+	}{
+		{etcd1, []string{certRoleAdmin, certRoleMember}},
+		{master1, []string{certRoleNode}},
+		{both, []string{certRoleAdmin, certRoleMember, certRoleNode}},
+		{neither, nil},
+	}
+
+	for _, c := range cases {
+		got := hostCertRoles(nil, runtime, c.host)
+		if len(got) != len(c.roles) {
+			t.Fatalf("host %s: got roles %v, want %v", c.host.GetName(), got, c.roles)
+		}
+		for i := range got {
+			if got[i] != c.roles[i] {
+				t.Fatalf("host %s: got roles %v, want %v", c.host.GetName(), got, c.roles)
+			}
+		}
+	}
+}
+
+// TestHostCertRolesGrantsKineMasterAdminAndMember asserts the kine/external fix: a Master host
+// with no dedicated ETCD-role host in the cluster still gets admin/member certs, because it is
+// the one serving the etcd-compatible gRPC surface itself.
+func TestHostCertRolesGrantsKineMasterAdminAndMember(t *testing.T) {
+	master1 := &fakeHost{name: "master1"}
+	runtime := &fakeRuntime{
+		masterHosts: []connector.Host{master1},
+	}
+	kubeConf := &common.KubeConf{}
+	kubeConf.Cluster.Etcd.Type = string(BackendKine)
+
+	got := hostCertRoles(kubeConf, runtime, master1)
+	want := []string{certRoleNode, certRoleAdmin, certRoleMember}
+	if len(got) != len(want) {
+		t.Fatalf("got roles %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("got roles %v, want %v", got, want)
+		}
+	}
+}
+
+// TestFetchCSRsNeverReferencesAnotherHostsKey asserts the property the request calls for: for
+// every host, every CSR file name FetchCSRs would ever fetch is named after the CSR's own host,
+// carries the role's key material only as far as a private key already on that host (it is
+// never part of the fetched file name or path), and is never a *-key.pem file belonging to a
+// different host.
+func TestFetchCSRsNeverReferencesAnotherHostsKey(t *testing.T) {
+	hosts := []connector.Host{&fakeHost{name: "etcd1"}, &fakeHost{name: "etcd2"}, &fakeHost{name: "master1"}}
+	runtime := &fakeRuntime{
+		etcdHosts:   []connector.Host{hosts[0], hosts[1]},
+		masterHosts: []connector.Host{hosts[2]},
+	}
+
+	for _, h := range hosts {
+		for _, role := range hostCertRoles(nil, runtime, h) {
+			name := csrFileName(role, h.GetName())
+
+			if strings.HasSuffix(name, "-key.pem") {
+				t.Fatalf("FetchCSRs would fetch a private key file: %s", name)
+			}
+			if !strings.HasSuffix(name, ".csr") {
+				t.Fatalf("unexpected file extension for %s", name)
+			}
+			if !strings.Contains(name, h.GetName()) {
+				t.Fatalf("csr file %s does not belong to the host it was derived for (%s)", name, h.GetName())
+			}
+			for _, other := range hosts {
+				if other.GetName() == h.GetName() {
+					continue
+				}
+				if strings.Contains(name, other.GetName()) {
+					t.Fatalf("csr file %s derived for host %s unexpectedly references host %s", name, h.GetName(), other.GetName())
+				}
+			}
+		}
+	}
+}
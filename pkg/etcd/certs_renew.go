@@ -0,0 +1,188 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package etcd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/connector"
+	"github.com/kubesphere/kubekey/pkg/core/util"
+	"github.com/kubesphere/kubekey/pkg/etcd/healthpoll"
+	"github.com/pkg/errors"
+)
+
+// DefaultCertRenewalThreshold is how close to expiry (30 days) a cert must be before RenewCerts
+// rotates it.
+const DefaultCertRenewalThreshold = 30 * 24 * time.Hour
+
+// CertExpiration describes the remaining validity of a single etcd certificate file.
+type CertExpiration struct {
+	Host     string
+	File     string
+	NotAfter time.Time
+}
+
+// RenewCerts inspects every etcd cert on the host and, if any is within the configured renewal
+// window, backs up the host's certs. On the first etcd host it then regenerates the full cert set
+// with make-ssl-etcd.sh and fetches it locally; redistribution and the health-gated rolling
+// restart are done by SyncCertsFile and RestartETCDRolling in NewRenewETCDCertsPipeline.
+type RenewCerts struct {
+	common.KubeAction
+	// RenewalWindow overrides DefaultCertRenewalThreshold when non-zero.
+	RenewalWindow time.Duration
+}
+
+func (r *RenewCerts) Execute(runtime connector.Runtime) error {
+	window := r.RenewalWindow
+	if window == 0 {
+		window = DefaultCertRenewalThreshold
+	}
+
+	expirations, err := certExpirations(runtime)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), "inspect etcd cert expiration failed")
+	}
+
+	needsRenewal := false
+	for _, e := range expirations {
+		if time.Until(e.NotAfter) < window {
+			needsRenewal = true
+			break
+		}
+	}
+	if !needsRenewal {
+		return nil
+	}
+
+	backupDir := filepath.Join(common.ETCDCertDir, fmt.Sprintf("backup-%s", time.Now().UTC().Format(SnapshotNameTimeFormat)))
+	if _, err := runtime.GetRunner().SudoCmd(fmt.Sprintf("mkdir -p %s && cp -a %s/*.pem %s/", backupDir, common.ETCDCertDir, backupDir), false); err != nil {
+		return errors.Wrap(errors.WithStack(err), "backup existing etcd certs failed")
+	}
+
+	etcdHosts := runtime.GetHostsByRole(common.ETCD)
+	if len(etcdHosts) == 0 {
+		// kine/external backends have no ETCD-role host to regenerate from; nothing to rotate
+		// here, RenewCerts just records the backup and returns.
+		return nil
+	}
+
+	if runtime.RemoteHost().GetName() == etcdHosts[0].GetName() {
+		regenCmd := fmt.Sprintf("chmod +x %s/make-ssl-etcd.sh && /bin/bash -x %s/make-ssl-etcd.sh -f %s/openssl.conf -d %s",
+			common.ETCDCertDir, common.ETCDCertDir, common.ETCDCertDir, common.ETCDCertDir)
+		if _, err := runtime.GetRunner().SudoCmd(regenCmd, false); err != nil {
+			return errors.Wrap(errors.WithStack(err), "regenerate etcd certs failed")
+		}
+
+		localCertsDir := filepath.Join(runtime.GetWorkDir(), "ETCD_certs_renew")
+		if err := util.CreateDir(localCertsDir); err != nil {
+			return err
+		}
+		files := generateCertsFiles(r.KubeConf, runtime)
+		for _, fileName := range files {
+			if err := runtime.GetRunner().Fetch(filepath.Join(localCertsDir, fileName), filepath.Join(common.ETCDCertDir, fileName)); err != nil {
+				return errors.Wrap(errors.WithStack(err), "fetch renewed etcd certs file failed")
+			}
+		}
+		r.ModuleCache.Set(LocalCertsDir, localCertsDir)
+		r.ModuleCache.Set(CertsFileList, files)
+	}
+
+	return nil
+}
+
+// certExpirations parses NotAfter for every cert under /etc/ssl/etcd/ssl on the remote host.
+func certExpirations(runtime connector.Runtime) ([]CertExpiration, error) {
+	host := runtime.RemoteHost()
+	listCmd := fmt.Sprintf("ls %s/*.pem 2>/dev/null", common.ETCDCertDir)
+	out, err := runtime.GetRunner().SudoCmd(listCmd, false)
+	if err != nil || strings.TrimSpace(out) == "" {
+		return nil, nil
+	}
+
+	var result []CertExpiration
+	for _, f := range strings.Split(strings.TrimSpace(out), "\n") {
+		// Exclude every private key, including the CA's own ca-key.pem, but keep ca.pem itself
+		// in the report: an expired CA is the single most disruptive cert to miss.
+		if strings.HasSuffix(f, "-key.pem") {
+			continue
+		}
+		notAfterCmd := fmt.Sprintf("openssl x509 -enddate -noout -in %s | cut -d= -f2", f)
+		dateOut, err := runtime.GetRunner().SudoCmd(notAfterCmd, false)
+		if err != nil {
+			continue
+		}
+		notAfter, err := time.Parse("Jan 2 15:04:05 2006 MST", strings.TrimSpace(dateOut))
+		if err != nil {
+			continue
+		}
+		result = append(result, CertExpiration{Host: host.GetName(), File: f, NotAfter: notAfter})
+	}
+	return result, nil
+}
+
+// RestartETCDRolling restarts etcd one member at a time, gating each step on a post-restart
+// endpoint health check before moving on to the next host.
+type RestartETCDRolling struct {
+	common.KubeAction
+}
+
+func (r *RestartETCDRolling) Execute(runtime connector.Runtime) error {
+	host := runtime.RemoteHost()
+	if _, err := runtime.GetRunner().SudoCmd("systemctl daemon-reload && systemctl restart etcd", true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "restart etcd failed")
+	}
+
+	healthCmd := fmt.Sprintf("export ETCDCTL_API=3;"+
+		"export ETCDCTL_CERT='/etc/ssl/etcd/ssl/admin-%s.pem';"+
+		"export ETCDCTL_KEY='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
+		"export ETCDCTL_CACERT='/etc/ssl/etcd/ssl/ca.pem';"+
+		"%s/etcdctl --endpoints=https://%s:2379 endpoint health",
+		host.GetName(), host.GetName(), common.BinDir, host.GetInternalAddress())
+
+	_, err := healthpoll.Retry(healthpoll.DefaultAttempts, healthpoll.DefaultInterval, func() (string, error) {
+		return runtime.GetRunner().SudoCmd(healthCmd, false)
+	}, func(string) bool { return true })
+	if err != nil {
+		return errors.Errorf("etcd member %s did not become healthy after restart", host.GetName())
+	}
+	return nil
+}
+
+// CheckExpiration prints a table of every etcd cert and its remaining validity, similar in
+// spirit to kubeadm's certificate expiration report.
+type CheckExpiration struct {
+	common.KubeAction
+}
+
+func (c *CheckExpiration) Execute(runtime connector.Runtime) error {
+	expirations, err := certExpirations(runtime)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), "inspect etcd cert expiration failed")
+	}
+
+	fmt.Printf("%-20s %-40s %-30s %s\n", "HOST", "CERT", "EXPIRES", "RESIDUAL")
+	for _, e := range expirations {
+		residual := strconv.Itoa(int(time.Until(e.NotAfter).Hours()/24)) + "d"
+		fmt.Printf("%-20s %-40s %-30s %s\n", e.Host, filepath.Base(e.File), e.NotAfter.Format(time.RFC3339), residual)
+	}
+	return nil
+}
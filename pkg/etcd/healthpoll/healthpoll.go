@@ -0,0 +1,135 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package healthpoll provides a shared retry/backoff helper for etcd cluster-health checks, so
+// that HealthCheck, CheckMember and the implicit checks after RestartETCD/JoinMember all give a
+// slow-to-settle cluster the same chance to come up before failing the pipeline.
+package healthpoll
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultAttempts is how many times a health probe is retried before giving up.
+	DefaultAttempts = 15
+	// DefaultInterval is the delay between retries.
+	DefaultInterval = 10 * time.Second
+)
+
+// ProbeFunc runs a single health probe and returns its raw output.
+type ProbeFunc func() (string, error)
+
+// Retry calls fn up to attempts times, sleeping interval between tries, and returns the last
+// output once ok reports the probe as acceptable. It returns the last error if every attempt is
+// exhausted.
+func Retry(attempts int, interval time.Duration, fn ProbeFunc, ok func(output string) bool) (string, error) {
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	var lastErr error
+	var lastOut string
+	for i := 0; i < attempts; i++ {
+		out, err := fn()
+		lastOut, lastErr = out, err
+		if err == nil && ok(out) {
+			return out, nil
+		}
+		if i < attempts-1 {
+			time.Sleep(interval)
+		}
+	}
+	if lastErr == nil {
+		lastErr = errors.New("health probe did not reach the expected state before the retry budget ran out")
+	}
+	return lastOut, lastErr
+}
+
+// RetryHealthyCount retries fn until its output contains the literal string "healthy" at least
+// wantCount times, matching the mantle/etcd test pattern of one occurrence per member endpoint
+// plus one for the cluster-wide line (2*clusterSize + 1).
+func RetryHealthyCount(attempts int, interval time.Duration, fn ProbeFunc, wantCount int) (string, error) {
+	return Retry(attempts, interval, fn, func(output string) bool {
+		return strings.Count(output, "healthy") >= wantCount
+	})
+}
+
+// MemberHealth is a single member's result from `etcdctl endpoint health --write-out=json`.
+type MemberHealth struct {
+	Endpoint string `json:"endpoint"`
+	Health   bool   `json:"health"`
+	Took     string `json:"took"`
+}
+
+// EtcdHealthReport is the structured result of a v3-API cluster health probe, cached in the
+// pipeline so downstream steps (upgrade, backup, restore) can gate on it.
+type EtcdHealthReport struct {
+	Healthy   bool
+	Members   []MemberHealth
+	CheckedAt time.Time
+}
+
+// ParseEndpointHealthJSON parses `etcdctl endpoint health --cluster --write-out=json` output,
+// verifying every member reports health:true and a `took` duration under tookThreshold.
+func ParseEndpointHealthJSON(output string, tookThreshold time.Duration) (*EtcdHealthReport, error) {
+	var members []MemberHealth
+	if err := json.Unmarshal([]byte(output), &members); err != nil {
+		return nil, errors.Wrap(errors.WithStack(err), "parse etcd endpoint health json failed")
+	}
+
+	report := &EtcdHealthReport{Members: members, Healthy: true, CheckedAt: time.Now()}
+	for _, m := range members {
+		took, err := time.ParseDuration(m.Took)
+		if err != nil || !m.Health || took > tookThreshold {
+			report.Healthy = false
+		}
+	}
+	return report, nil
+}
+
+// RetryEndpointHealthJSON retries a v3-API JSON health probe until every member is healthy and
+// within tookThreshold, returning the structured report.
+func RetryEndpointHealthJSON(attempts int, interval time.Duration, fn ProbeFunc, tookThreshold time.Duration) (*EtcdHealthReport, error) {
+	var report *EtcdHealthReport
+	_, err := Retry(attempts, interval, func() (string, error) {
+		out, err := fn()
+		if err != nil {
+			return out, err
+		}
+		r, perr := ParseEndpointHealthJSON(out, tookThreshold)
+		if perr != nil {
+			return out, perr
+		}
+		report = r
+		if !r.Healthy {
+			return out, errors.New("etcd cluster is not yet fully healthy")
+		}
+		return out, nil
+	}, func(string) bool { return report != nil && report.Healthy })
+
+	if err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,396 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kubekeyapiv1alpha2 "github.com/kubesphere/kubekey/apis/kubekey/v1alpha2"
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/action"
+	"github.com/kubesphere/kubekey/pkg/core/connector"
+	"github.com/kubesphere/kubekey/pkg/core/util"
+	"github.com/kubesphere/kubekey/pkg/etcd/templates"
+	"github.com/kubesphere/kubekey/pkg/version"
+	"github.com/pkg/errors"
+)
+
+// SnapshotNameTimeFormat is used to build the timestamped snapshot file name, e.g. snapshot-node1-20210101-120000.db.
+const SnapshotNameTimeFormat = "20060102-150405"
+
+// snapshotNamePattern matches the "snapshot-<hostname>-<timestamp>.db" names SnapshotETCD
+// produces, capturing the hostname the snapshot was taken on.
+var snapshotNamePattern = regexp.MustCompile(`^snapshot-(.+)-\d{8}-\d{6}\.db$`)
+
+// SnapshotOriginHost extracts the hostname embedded in a snapshot file name. Only that host is
+// guaranteed to have the file on local disk; every other member restores by rejoining it instead
+// of restoring the same snapshot independently. It backs pipelines.NewRestoreETCDPipeline's
+// choice of which host runs RestoreETCD.
+func SnapshotOriginHost(snapshotName string) (string, bool) {
+	m := snapshotNamePattern.FindStringSubmatch(snapshotName)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// SnapshotMeta is the companion metadata recorded next to every etcd snapshot so that a restore
+// can be matched back to the cluster it was taken from.
+type SnapshotMeta struct {
+	ClusterID       string   `json:"clusterID"`
+	MemberList      []string `json:"memberList"`
+	InitialCluster  string   `json:"initialCluster"`
+	KubernetesVer   string   `json:"kubernetesVersion"`
+	KubeKeyVer      string   `json:"kubekeyVersion"`
+	SnapshotFile    string   `json:"snapshotFile"`
+	SnapshotSHA256  string   `json:"snapshotSha256"`
+	CreationTimeUTC string   `json:"creationTimeUtc"`
+}
+
+// SnapshotETCD takes a v3-API etcd snapshot on the local node and writes a companion metadata
+// file describing it, replacing the old cron-script approach with an auditable, first-class step.
+type SnapshotETCD struct {
+	common.KubeAction
+}
+
+func (s *SnapshotETCD) Execute(runtime connector.Runtime) error {
+	host := runtime.RemoteHost()
+
+	v, ok := s.PipelineCache.Get(common.ETCDCluster)
+	if !ok {
+		return errors.New("get etcd cluster status by pipeline cache failed")
+	}
+	cluster := v.(*EtcdCluster)
+
+	backupDir := s.KubeConf.Cluster.Kubernetes.EtcdBackupDir
+	if _, err := runtime.GetRunner().SudoCmd(fmt.Sprintf("mkdir -p %s", backupDir), false); err != nil {
+		return errors.Wrap(errors.WithStack(err), "create etcd snapshot dir failed")
+	}
+
+	timestamp := snapshotTimestamp()
+	snapshotName := fmt.Sprintf("snapshot-%s-%s.db", host.GetName(), timestamp)
+	snapshotPath := filepath.Join(backupDir, snapshotName)
+
+	saveCmd := fmt.Sprintf("export ETCDCTL_API=3;"+
+		"export ETCDCTL_CERT='/etc/ssl/etcd/ssl/admin-%s.pem';"+
+		"export ETCDCTL_KEY='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
+		"export ETCDCTL_CACERT='/etc/ssl/etcd/ssl/ca.pem';"+
+		"%s/etcdctl --endpoints=https://%s:2379 snapshot save %s",
+		host.GetName(), host.GetName(), common.BinDir, host.GetInternalAddress(), snapshotPath)
+	if _, err := runtime.GetRunner().SudoCmd(saveCmd, true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "save etcd snapshot failed")
+	}
+
+	sha256Out, err := runtime.GetRunner().SudoCmd(fmt.Sprintf("sha256sum %s | awk '{print $1}'", snapshotPath), false)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), "compute etcd snapshot sha256 failed")
+	}
+
+	clusterID, err := etcdClusterID(runtime)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), "get etcd cluster id failed")
+	}
+
+	meta := SnapshotMeta{
+		ClusterID:       clusterID,
+		MemberList:      cluster.peerAddresses,
+		InitialCluster:  strings.Join(cluster.peerAddresses, ","),
+		KubernetesVer:   s.KubeConf.Cluster.Kubernetes.Version,
+		KubeKeyVer:      version.VERSION,
+		SnapshotFile:    snapshotName,
+		SnapshotSHA256:  strings.TrimSpace(sha256Out),
+		CreationTimeUTC: timestamp,
+	}
+
+	metaPath := filepath.Join(backupDir, fmt.Sprintf("%s.json", strings.TrimSuffix(snapshotName, ".db")))
+	metaAction := action.Template{
+		Template: templates.EtcdSnapshotMeta,
+		Dst:      metaPath,
+		Data: util.Data{
+			"ClusterID":       meta.ClusterID,
+			"MemberList":      meta.MemberList,
+			"InitialCluster":  meta.InitialCluster,
+			"KubernetesVer":   meta.KubernetesVer,
+			"KubeKeyVer":      meta.KubeKeyVer,
+			"SnapshotFile":    meta.SnapshotFile,
+			"SnapshotSHA256":  meta.SnapshotSHA256,
+			"CreationTimeUTC": meta.CreationTimeUTC,
+		},
+	}
+	metaAction.Init(nil, nil)
+	if err := metaAction.Execute(runtime); err != nil {
+		return errors.Wrap(errors.WithStack(err), "write etcd snapshot metadata failed")
+	}
+
+	if s3 := s.KubeConf.Cluster.Kubernetes.EtcdSnapshotS3; s3 != nil && s3.Endpoint != "" {
+		if err := uploadSnapshotToS3(runtime, s3, snapshotPath, metaPath); err != nil {
+			return errors.Wrap(errors.WithStack(err), "upload etcd snapshot to s3 failed")
+		}
+	}
+
+	s.ModuleCache.Set(common.ETCDSnapshotPath, snapshotPath)
+	s.ModuleCache.Set(common.ETCDSnapshotMetaPath, metaPath)
+	return nil
+}
+
+func snapshotTimestamp() string {
+	return time.Now().UTC().Format(SnapshotNameTimeFormat)
+}
+
+// etcdClusterID queries the etcd member for its actual Cluster ID (as reported by
+// `endpoint status`), rather than the hostname of the node that happened to take the
+// snapshot, so a restore tool can match a snapshot back to the cluster it belongs to.
+func etcdClusterID(runtime connector.Runtime) (string, error) {
+	host := runtime.RemoteHost()
+	statusCmd := fmt.Sprintf("export ETCDCTL_API=3;"+
+		"export ETCDCTL_CERT='/etc/ssl/etcd/ssl/admin-%s.pem';"+
+		"export ETCDCTL_KEY='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
+		"export ETCDCTL_CACERT='/etc/ssl/etcd/ssl/ca.pem';"+
+		"%s/etcdctl --endpoints=https://%s:2379 endpoint status --write-out=json",
+		host.GetName(), host.GetName(), common.BinDir, host.GetInternalAddress())
+
+	out, err := runtime.GetRunner().SudoCmd(statusCmd, false)
+	if err != nil {
+		return "", err
+	}
+
+	var statuses []struct {
+		Status struct {
+			Header struct {
+				ClusterId uint64 `json:"cluster_id"`
+			} `json:"header"`
+		} `json:"Status"`
+	}
+	if err := json.Unmarshal([]byte(out), &statuses); err != nil || len(statuses) == 0 {
+		return "", errors.New("parse etcd endpoint status for cluster id failed")
+	}
+	return strconv.FormatUint(statuses[0].Status.Header.ClusterId, 16), nil
+}
+
+// uploadSnapshotToS3 pushes the snapshot and its metadata JSON to the configured S3/MinIO bucket
+// using the mc client installed alongside the cluster tooling. Credentials are written to a
+// file sourced by the shell rather than interpolated into the command line, so they never show
+// up in the process list.
+func uploadSnapshotToS3(runtime connector.Runtime, s3 *kubekeyapiv1alpha2.EtcdSnapshotS3, snapshotPath, metaPath string) error {
+	_, remoteBase, envFile, err := s3McSetup(runtime, s3)
+	if err != nil {
+		return err
+	}
+	defer runtime.GetRunner().SudoCmd(fmt.Sprintf("rm -f %s", envFile), false) //nolint:errcheck
+
+	for _, f := range []string{snapshotPath, metaPath} {
+		cpCmd := fmt.Sprintf("set -a && . %s && set +a && mc cp %s %s/%s", envFile, f, remoteBase, filepath.Base(f))
+		if _, err := runtime.GetRunner().SudoCmd(cpCmd, false); err != nil {
+			return errors.Wrap(errors.WithStack(err), fmt.Sprintf("upload %s failed", filepath.Base(f)))
+		}
+	}
+	return nil
+}
+
+// s3McSetup writes the mc alias credentials for s3 to a 0600 file under common.TmpDir instead
+// of passing AccessKey/SecretKey on argv, and returns the alias name, the remote bucket/folder
+// path to copy to, and the path of the credentials file to source before each mc invocation.
+func s3McSetup(runtime connector.Runtime, s3 *kubekeyapiv1alpha2.EtcdSnapshotS3) (alias, remoteBase, envFile string, err error) {
+	// the alias becomes part of the MC_HOST_<alias> variable name, so it must be a valid shell name.
+	alias = "kk_etcd_snapshot"
+	folder := strings.Trim(s3.Folder, "/")
+	remoteBase = fmt.Sprintf("%s/%s", alias, strings.TrimSuffix(fmt.Sprintf("%s/%s", s3.Bucket, folder), "/"))
+
+	scheme := "https"
+	if s3.Insecure {
+		scheme = "http"
+	}
+
+	envFile = filepath.Join(common.TmpDir, fmt.Sprintf(".mc-%s.env", alias))
+	envContents := fmt.Sprintf("MC_HOST_%s=%s://%s:%s@%s", alias, scheme, s3.AccessKey, s3.SecretKey, s3.Endpoint)
+	writeCmd := fmt.Sprintf("umask 077 && cat > %s <<'KKEOF'\n%s\nKKEOF", envFile, envContents)
+	if _, err := runtime.GetRunner().SudoCmd(writeCmd, true); err != nil {
+		return "", "", "", errors.Wrap(errors.WithStack(err), "write mc credentials file for etcd snapshot transfer failed")
+	}
+	return alias, remoteBase, envFile, nil
+}
+
+// PruneSnapshots removes local etcd snapshots beyond the configured count and age based
+// retention, keeping the newest KeepBackupNumber files and dropping anything older than
+// EtcdBackupRetentionDuration.
+type PruneSnapshots struct {
+	common.KubeAction
+}
+
+func (p *PruneSnapshots) Execute(runtime connector.Runtime) error {
+	backupDir := p.KubeConf.Cluster.Kubernetes.EtcdBackupDir
+	keep := p.KubeConf.Cluster.Kubernetes.KeepBackupNumber
+	retention := p.KubeConf.Cluster.Kubernetes.EtcdBackupRetentionDuration
+
+	listCmd := fmt.Sprintf("ls -1 %s/snapshot-*.db 2>/dev/null | sort", backupDir)
+	out, err := runtime.GetRunner().SudoCmd(listCmd, false)
+	if err != nil || strings.TrimSpace(out) == "" {
+		// nothing to prune yet.
+		return nil
+	}
+
+	files := strings.Split(strings.TrimSpace(out), "\n")
+	sort.Strings(files)
+
+	toRemove := map[string]bool{}
+	if keep > 0 && len(files) > keep {
+		for _, f := range files[:len(files)-keep] {
+			toRemove[f] = true
+		}
+	}
+
+	if retention != "" {
+		maxAge, err := time.ParseDuration(retention)
+		if err != nil {
+			return errors.Wrap(errors.WithStack(err), "parse EtcdBackupRetentionDuration failed")
+		}
+		cutoff := time.Now().Add(-maxAge)
+		for _, f := range files {
+			age, err := snapshotAge(runtime, f)
+			if err != nil {
+				continue
+			}
+			if age.Before(cutoff) {
+				toRemove[f] = true
+			}
+		}
+	}
+
+	for f := range toRemove {
+		metaFile := strings.TrimSuffix(f, ".db") + ".json"
+		if _, err := runtime.GetRunner().SudoCmd(fmt.Sprintf("rm -f %s %s", f, metaFile), false); err != nil {
+			return errors.Wrap(errors.WithStack(err), fmt.Sprintf("prune etcd snapshot %s failed", f))
+		}
+	}
+	return nil
+}
+
+func snapshotAge(runtime connector.Runtime, file string) (time.Time, error) {
+	out, err := runtime.GetRunner().SudoCmd(fmt.Sprintf("stat -c %%Y %s", file), false)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sec, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// RestoreETCD restores a single-node etcd cluster from a named snapshot, pulling it from local
+// disk or S3 if it is not already present. It is meant to run on exactly one host: the one named
+// in the snapshot's file name, which is the only member guaranteed to have it on local disk.
+// Every other member then rejoins the restored cluster fresh via WipeMemberData/JoinMember
+// instead of restoring the same snapshot independently.
+type RestoreETCD struct {
+	common.KubeAction
+	// SnapshotName is the file name of the snapshot to restore, without any directory prefix.
+	SnapshotName string
+}
+
+func (r *RestoreETCD) Execute(runtime connector.Runtime) error {
+	host := runtime.RemoteHost()
+	backupDir := r.KubeConf.Cluster.Kubernetes.EtcdBackupDir
+	snapshotPath := filepath.Join(backupDir, r.SnapshotName)
+
+	exist, err := runtime.GetRunner().FileExist(snapshotPath)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		if s3 := r.KubeConf.Cluster.Kubernetes.EtcdSnapshotS3; s3 != nil && s3.Endpoint != "" {
+			if err := downloadSnapshotFromS3(runtime, s3, r.SnapshotName, backupDir); err != nil {
+				return errors.Wrap(errors.WithStack(err), "download etcd snapshot from s3 failed")
+			}
+		} else {
+			return errors.Errorf("etcd snapshot %s not found locally and no S3 backend configured", r.SnapshotName)
+		}
+	}
+
+	etcdName, ok := host.GetCache().GetMustString(common.ETCDName)
+	if !ok {
+		return errors.New("get etcd node status by host label failed")
+	}
+
+	if _, err := runtime.GetRunner().SudoCmd("systemctl stop etcd", true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "stop etcd before restore failed")
+	}
+
+	// Restore bootstraps a single-node cluster on this host alone: every other member rejoins it
+	// fresh afterwards (see pipelines.NewRestoreETCDPipeline), so --initial-cluster must name
+	// only this member, not cluster.peerAddresses.
+	selfPeerURL := fmt.Sprintf("%s=https://%s:2380", etcdName, host.GetInternalAddress())
+
+	dataDir := "/var/lib/etcd"
+	restoredDir := fmt.Sprintf("%s.restored", dataDir)
+	restoreCmd := fmt.Sprintf("%s/etcdutl snapshot restore %s --name %s --initial-cluster %s "+
+		"--initial-advertise-peer-urls https://%s:2380 --data-dir %s",
+		common.BinDir, snapshotPath, etcdName, selfPeerURL, host.GetInternalAddress(), restoredDir)
+	if _, err := runtime.GetRunner().SudoCmd(restoreCmd, true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "restore etcd snapshot failed")
+	}
+
+	reseedCmd := fmt.Sprintf("rm -rf %s && mv %s %s", dataDir, restoredDir, dataDir)
+	if _, err := runtime.GetRunner().SudoCmd(reseedCmd, false); err != nil {
+		return errors.Wrap(errors.WithStack(err), "reseed etcd data dir failed")
+	}
+
+	if _, err := runtime.GetRunner().SudoCmd("systemctl daemon-reload && systemctl restart etcd && systemctl enable etcd", true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "start etcd after restore failed")
+	}
+	return nil
+}
+
+// WipeMemberData removes this host's etcd data directory so it can rejoin as a fresh learner
+// against the single-node cluster RestoreETCD just bootstrapped on the snapshot's origin host.
+type WipeMemberData struct {
+	common.KubeAction
+}
+
+func (w *WipeMemberData) Execute(runtime connector.Runtime) error {
+	if _, err := runtime.GetRunner().SudoCmd("systemctl stop etcd", true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "stop etcd before wiping data dir failed")
+	}
+	if _, err := runtime.GetRunner().SudoCmd("rm -rf /var/lib/etcd", false); err != nil {
+		return errors.Wrap(errors.WithStack(err), "wipe etcd data dir failed")
+	}
+	return nil
+}
+
+func downloadSnapshotFromS3(runtime connector.Runtime, s3 *kubekeyapiv1alpha2.EtcdSnapshotS3, name, destDir string) error {
+	_, remoteBase, envFile, err := s3McSetup(runtime, s3)
+	if err != nil {
+		return err
+	}
+	defer runtime.GetRunner().SudoCmd(fmt.Sprintf("rm -f %s", envFile), false) //nolint:errcheck
+
+	metaName := strings.TrimSuffix(name, ".db") + ".json"
+	for _, f := range []string{name, metaName} {
+		getCmd := fmt.Sprintf("set -a && . %s && set +a && mc cp %s/%s %s", envFile, remoteBase, f, filepath.Join(destDir, f))
+		if _, err := runtime.GetRunner().SudoCmd(getCmd, false); err != nil {
+			return errors.Wrap(errors.WithStack(err), fmt.Sprintf("download %s failed", f))
+		}
+	}
+	return nil
+}
@@ -17,15 +17,19 @@
 package etcd
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	kubekeyapiv1alpha2 "github.com/kubesphere/kubekey/apis/kubekey/v1alpha2"
 	"github.com/kubesphere/kubekey/pkg/common"
 	"github.com/kubesphere/kubekey/pkg/core/action"
 	"github.com/kubesphere/kubekey/pkg/core/connector"
 	"github.com/kubesphere/kubekey/pkg/core/util"
+	"github.com/kubesphere/kubekey/pkg/etcd/healthpoll"
 	"github.com/kubesphere/kubekey/pkg/etcd/templates"
 	"github.com/kubesphere/kubekey/pkg/utils"
 	"github.com/pkg/errors"
@@ -56,6 +60,17 @@ type GetStatus struct {
 }
 
 func (g *GetStatus) Execute(runtime connector.Runtime) error {
+	if etcdBackend(g.KubeConf) != BackendKubeKey {
+		// external and kine backends never probe for a locally installed etcd.
+		host := runtime.RemoteHost()
+		host.GetCache().Set(common.ETCDName, fmt.Sprintf("etcd-%s", host.GetName()))
+		host.GetCache().Set(common.ETCDExist, true)
+		if _, ok := g.PipelineCache.Get(common.ETCDCluster); !ok {
+			g.PipelineCache.Set(common.ETCDCluster, &EtcdCluster{clusterExist: true})
+		}
+		return nil
+	}
+
 	exist, err := runtime.GetRunner().FileExist("/etc/etcd.env")
 	if err != nil {
 		return err
@@ -103,48 +118,20 @@ func (g *GetStatus) Execute(runtime connector.Runtime) error {
 	return nil
 }
 
-type ExecCertsScript struct {
-	common.KubeAction
-}
-
-func (e *ExecCertsScript) Execute(runtime connector.Runtime) error {
-	_, err := runtime.GetRunner().SudoCmd(fmt.Sprintf("chmod +x %s/make-ssl-etcd.sh", common.ETCDCertDir), false)
-	if err != nil {
-		return err
-	}
-
-	cmd := fmt.Sprintf("/bin/bash -x %s/make-ssl-etcd.sh -f %s/openssl.conf -d %s", common.ETCDCertDir, common.ETCDCertDir, common.ETCDCertDir)
-	if _, err := runtime.GetRunner().SudoCmd(cmd, false); err != nil {
-		return errors.Wrap(errors.WithStack(err), "generate etcd certs failed")
-	}
-
-	tmpCertsDir := filepath.Join(common.TmpDir, "ETCD_certs")
-	if _, err := runtime.GetRunner().SudoCmd(fmt.Sprintf("cp -r %s %s", common.ETCDCertDir, tmpCertsDir), false); err != nil {
-		return errors.Wrap(errors.WithStack(err), "copy certs result failed")
-	}
-
-	localCertsDir := filepath.Join(runtime.GetWorkDir(), "ETCD_certs")
-	if err := util.CreateDir(localCertsDir); err != nil {
-		return err
-	}
-
-	files := generateCertsFiles(runtime)
-	for _, fileName := range files {
-		if err := runtime.GetRunner().Fetch(filepath.Join(localCertsDir, fileName), filepath.Join(tmpCertsDir, fileName)); err != nil {
-			return errors.Wrap(errors.WithStack(err), "fetch etcd certs file failed")
-		}
-	}
-
-	e.ModuleCache.Set(LocalCertsDir, localCertsDir)
-	e.ModuleCache.Set(CertsFileList, files)
-	return nil
-}
-
-func generateCertsFiles(runtime connector.Runtime) []string {
+// generateCertsFiles lists every cert/key file the install/renewal flows expect to exist, so
+// RenewCerts knows what to fetch and SyncCertsFile knows what to distribute. Initial bootstrap
+// instead goes through CertSigner/GenerateHostKeyAndCSR/FetchCSRs/SignCSRs/DistributeSignedCerts
+// in certs_bootstrap.go, which never moves a private key across the network. On a
+// kine/external backend there is no common.ETCD-role host, so the Master hosts that actually
+// serve the etcd-compatible gRPC surface get the admin/member pair instead - mirroring
+// hostCertRoles in certs_bootstrap.go.
+func generateCertsFiles(kubeConf *common.KubeConf, runtime connector.Runtime) []string {
 	var certsList []string
 	certsList = append(certsList, "ca.pem")
 	certsList = append(certsList, "ca-key.pem")
-	for _, host := range runtime.GetHostsByRole(common.ETCD) {
+
+	etcdHosts := runtime.GetHostsByRole(common.ETCD)
+	for _, host := range etcdHosts {
 		certsList = append(certsList, fmt.Sprintf("admin-%s.pem", host.GetName()))
 		certsList = append(certsList, fmt.Sprintf("admin-%s-key.pem", host.GetName()))
 		certsList = append(certsList, fmt.Sprintf("member-%s.pem", host.GetName()))
@@ -153,6 +140,12 @@ func generateCertsFiles(runtime connector.Runtime) []string {
 	for _, host := range runtime.GetHostsByRole(common.Master) {
 		certsList = append(certsList, fmt.Sprintf("node-%s.pem", host.GetName()))
 		certsList = append(certsList, fmt.Sprintf("node-%s-key.pem", host.GetName()))
+		if len(etcdHosts) == 0 && kubeConf != nil && etcdBackend(kubeConf) != BackendKubeKey {
+			certsList = append(certsList, fmt.Sprintf("admin-%s.pem", host.GetName()))
+			certsList = append(certsList, fmt.Sprintf("admin-%s-key.pem", host.GetName()))
+			certsList = append(certsList, fmt.Sprintf("member-%s.pem", host.GetName()))
+			certsList = append(certsList, fmt.Sprintf("member-%s-key.pem", host.GetName()))
+		}
 	}
 	return certsList
 }
@@ -209,12 +202,17 @@ type GenerateAccessAddress struct {
 }
 
 func (g *GenerateAccessAddress) Execute(runtime connector.Runtime) error {
-	var addrList []string
-	for _, host := range runtime.GetHostsByRole(common.ETCD) {
-		addrList = append(addrList, fmt.Sprintf("https://%s:2379", host.GetInternalAddress()))
+	var accessAddresses string
+	if addrs, handled := generateAccessAddressForBackend(g.KubeConf, runtime); handled {
+		accessAddresses = addrs
+	} else {
+		var addrList []string
+		for _, host := range runtime.GetHostsByRole(common.ETCD) {
+			addrList = append(addrList, fmt.Sprintf("https://%s:2379", host.GetInternalAddress()))
+		}
+		accessAddresses = strings.Join(addrList, ",")
 	}
 
-	accessAddresses := strings.Join(addrList, ",")
 	if v, ok := g.PipelineCache.Get(common.ETCDCluster); ok {
 		cluster := v.(*EtcdCluster)
 		cluster.accessAddresses = accessAddresses
@@ -230,36 +228,118 @@ type HealthCheck struct {
 }
 
 func (h *HealthCheck) Execute(runtime connector.Runtime) error {
-	if v, ok := h.PipelineCache.Get(common.ETCDCluster); ok {
-		cluster := v.(*EtcdCluster)
-		if err := healthCheck(runtime, cluster); err != nil {
-			return err
-		}
-	} else {
+	v, ok := h.PipelineCache.Get(common.ETCDCluster)
+	if !ok {
 		return errors.New("get etcd cluster status by pipeline cache failed")
 	}
-	return nil
+	cluster := v.(*EtcdCluster)
+
+	if handled, err := healthCheckForBackend(h.KubeConf, runtime, cluster); handled {
+		return err
+	}
+	return healthCheck(runtime, cluster)
 }
 
+// healthCheck retries `etcdctl cluster-health` until the output reports "healthy" once per
+// member endpoint plus once for the cluster-wide line (2*clusterSize + 1 occurrences), rather
+// than accepting or failing on a single attempt.
 func healthCheck(runtime connector.Runtime, cluster *EtcdCluster) error {
 	host := runtime.RemoteHost()
 	checkHealthCmd := fmt.Sprintf("export ETCDCTL_API=2;"+
 		"export ETCDCTL_CERT_FILE='/etc/ssl/etcd/ssl/admin-%s.pem';"+
 		"export ETCDCTL_KEY_FILE='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
 		"export ETCDCTL_CA_FILE='/etc/ssl/etcd/ssl/ca.pem';"+
-		"%s/etcdctl --endpoints=%s cluster-health | grep -q 'cluster is healthy'",
+		"%s/etcdctl --endpoints=%s cluster-health",
 		host.GetName(), host.GetName(), common.BinDir, cluster.accessAddresses)
-	if _, err := runtime.GetRunner().SudoCmd(checkHealthCmd, false); err != nil {
+
+	clusterSize := len(cluster.peerAddresses)
+	if clusterSize == 0 {
+		clusterSize = 1
+	}
+	wantCount := 2*clusterSize + 1
+
+	attempts := healthpoll.DefaultAttempts
+	interval := healthpoll.DefaultInterval
+
+	_, err := healthpoll.RetryHealthyCount(attempts, interval, func() (string, error) {
+		return runtime.GetRunner().SudoCmd(checkHealthCmd, false)
+	}, wantCount)
+	if err != nil {
 		return errors.Wrap(errors.WithStack(err), "etcd health check failed")
 	}
 	return nil
 }
 
+// healthCheckV3 polls the v3-API `etcdctl endpoint health --cluster --write-out=json` probe and
+// returns a structured EtcdHealthReport once every member is healthy and within the threshold,
+// or the retry budget is exhausted.
+func healthCheckV3(runtime connector.Runtime, cluster *EtcdCluster) (*healthpoll.EtcdHealthReport, error) {
+	host := runtime.RemoteHost()
+	checkHealthCmd := fmt.Sprintf("export ETCDCTL_API=3;"+
+		"export ETCDCTL_CERT='/etc/ssl/etcd/ssl/admin-%s.pem';"+
+		"export ETCDCTL_KEY='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
+		"export ETCDCTL_CACERT='/etc/ssl/etcd/ssl/ca.pem';"+
+		"%s/etcdctl --endpoints=%s endpoint health --cluster --write-out=json",
+		host.GetName(), host.GetName(), common.BinDir, cluster.accessAddresses)
+
+	return healthpoll.RetryEndpointHealthJSON(healthpoll.DefaultAttempts, healthpoll.DefaultInterval, func() (string, error) {
+		return runtime.GetRunner().SudoCmd(checkHealthCmd, false)
+	}, 5*time.Second)
+}
+
+// HealthCheckV3 runs healthCheckV3 and caches the resulting EtcdHealthReport in the pipeline
+// cache so later steps (upgrade, backup, restore) and `kk etcd status` can read it back.
+type HealthCheckV3 struct {
+	common.KubeAction
+}
+
+func (h *HealthCheckV3) Execute(runtime connector.Runtime) error {
+	v, ok := h.PipelineCache.Get(common.ETCDCluster)
+	if !ok {
+		return errors.New("get etcd cluster status by pipeline cache failed")
+	}
+	cluster := v.(*EtcdCluster)
+
+	report, err := healthCheckV3(runtime, cluster)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), "etcd v3 health check failed")
+	}
+
+	h.PipelineCache.Set(common.ETCDHealthReport, report)
+	return nil
+}
+
+// PrintHealthReport prints the cached EtcdHealthReport in tabular form; it backs the
+// `kk etcd status` subcommand.
+type PrintHealthReport struct {
+	common.KubeAction
+}
+
+func (p *PrintHealthReport) Execute(runtime connector.Runtime) error {
+	v, ok := p.PipelineCache.Get(common.ETCDHealthReport)
+	if !ok {
+		return errors.New("get etcd health report by pipeline cache failed, run HealthCheckV3 first")
+	}
+	report := v.(*healthpoll.EtcdHealthReport)
+
+	fmt.Printf("%-45s %-8s %-10s\n", "ENDPOINT", "HEALTHY", "TOOK")
+	for _, m := range report.Members {
+		fmt.Printf("%-45s %-8t %-10s\n", m.Endpoint, m.Health, m.Took)
+	}
+	fmt.Printf("\ncluster healthy: %t (checked at %s)\n", report.Healthy, report.CheckedAt.Format(time.RFC3339))
+	return nil
+}
+
 type GenerateConfig struct {
 	common.KubeAction
 }
 
 func (g *GenerateConfig) Execute(runtime connector.Runtime) error {
+	if backend := etcdBackend(g.KubeConf); backend != BackendKubeKey {
+		// external and kine backends don't run the binary-etcd config template.
+		return nil
+	}
+
 	host := runtime.RemoteHost()
 	etcdName, ok := host.GetCache().GetMustString(common.ETCDName)
 	if !ok {
@@ -353,6 +433,18 @@ func refreshConfig(runtime connector.Runtime, endpoints []string, state, etcdNam
 	return nil
 }
 
+// learnerCatchUpDeadline bounds how long JoinMember will wait for a newly joined learner to
+// catch up on the raft log before giving up.
+const learnerCatchUpDeadline = 5 * time.Minute
+
+// raftIndexDelta is the maximum allowed gap between a learner's raftAppliedIndex and the
+// leader's raftIndex before the learner is considered caught up and safe to promote.
+const raftIndexDelta = 100
+
+// JoinMember adds the host as a non-voting etcd learner via the v3 API, waits for it to catch
+// up on the raft log, and leaves promotion to a separate PromoteLearner step. Joining as a
+// learner first avoids the quorum flap that a voting member lagging behind on a slow disk can
+// cause.
 type JoinMember struct {
 	common.KubeAction
 }
@@ -364,25 +456,165 @@ func (j *JoinMember) Execute(runtime connector.Runtime) error {
 		return errors.New("get etcd node status by host label failed")
 	}
 
-	if v, ok := j.PipelineCache.Get(common.ETCDCluster); ok {
-		cluster := v.(*EtcdCluster)
-		joinMemberCmd := fmt.Sprintf("export ETCDCTL_API=2;"+
-			"export ETCDCTL_CERT_FILE='/etc/ssl/etcd/ssl/admin-%s.pem';"+
-			"export ETCDCTL_KEY_FILE='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
-			"export ETCDCTL_CA_FILE='/etc/ssl/etcd/ssl/ca.pem';"+
-			"%s/etcdctl --endpoints=%s member add %s %s",
-			host.GetName(), host.GetName(), common.BinDir, cluster.accessAddresses, etcdName,
-			fmt.Sprintf("https://%s:2380", host.GetInternalAddress()))
+	v, ok := j.PipelineCache.Get(common.ETCDCluster)
+	if !ok {
+		return errors.New("get etcd cluster status by pipeline cache failed")
+	}
+	cluster := v.(*EtcdCluster)
 
-		if _, err := runtime.GetRunner().SudoCmd(joinMemberCmd, true); err != nil {
-			return errors.Wrap(errors.WithStack(err), "add etcd member failed")
+	joinMemberCmd := fmt.Sprintf("export ETCDCTL_API=3;"+
+		"export ETCDCTL_CERT='/etc/ssl/etcd/ssl/admin-%s.pem';"+
+		"export ETCDCTL_KEY='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
+		"export ETCDCTL_CACERT='/etc/ssl/etcd/ssl/ca.pem';"+
+		"%s/etcdctl --endpoints=%s member add %s --learner --peer-urls=%s",
+		host.GetName(), host.GetName(), common.BinDir, cluster.accessAddresses, etcdName,
+		fmt.Sprintf("https://%s:2380", host.GetInternalAddress()))
+
+	out, err := runtime.GetRunner().SudoCmd(joinMemberCmd, true)
+	if err != nil {
+		if isClusterIDMismatch(out) {
+			return errors.Wrap(errors.WithStack(err),
+				"etcd cluster ID mismatch: the joining node's data directory belongs to a different "+
+					"cluster, wipe /var/lib/etcd on this host before retrying the join")
 		}
-	} else {
+		return errors.Wrap(errors.WithStack(err), "add etcd learner member failed")
+	}
+
+	if _, err := runtime.GetRunner().SudoCmd("systemctl daemon-reload && systemctl restart etcd && systemctl enable etcd", true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "start etcd on learner failed")
+	}
+
+	return waitLearnerCaughtUp(runtime, cluster, etcdName)
+}
+
+// isClusterIDMismatch matches etcd's ErrClusterIdMismatch class of errors so JoinMember can
+// surface a clear diagnostic instead of the generic wrap.
+func isClusterIDMismatch(output string) bool {
+	return strings.Contains(output, "cluster ID mismatch")
+}
+
+// waitLearnerCaughtUp polls `etcdctl endpoint status` against the leader until the learner's
+// raftAppliedIndex is within raftIndexDelta of the leader's raftIndex, or the deadline expires.
+func waitLearnerCaughtUp(runtime connector.Runtime, cluster *EtcdCluster, etcdName string) error {
+	host := runtime.RemoteHost()
+	statusCmd := fmt.Sprintf("export ETCDCTL_API=3;"+
+		"export ETCDCTL_CERT='/etc/ssl/etcd/ssl/admin-%s.pem';"+
+		"export ETCDCTL_KEY='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
+		"export ETCDCTL_CACERT='/etc/ssl/etcd/ssl/ca.pem';"+
+		"%s/etcdctl --endpoints=%s endpoint status --write-out=json",
+		host.GetName(), host.GetName(), common.BinDir, cluster.accessAddresses)
+
+	deadline := time.Now().Add(learnerCatchUpDeadline)
+	for {
+		out, err := runtime.GetRunner().SudoCmd(statusCmd, false)
+		if err == nil {
+			leaderIndex, learnerIndex, perr := parseEndpointStatus(out, host.GetInternalAddress())
+			if perr == nil && leaderIndex-learnerIndex <= raftIndexDelta {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return errors.Errorf("etcd learner %s did not catch up within %s", etcdName, learnerCatchUpDeadline)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// parseEndpointStatus extracts the cluster-wide max raftIndex and the given learner's
+// raftAppliedIndex out of `etcdctl endpoint status --write-out=json` output.
+func parseEndpointStatus(output, learnerAddr string) (leaderIndex, learnerIndex int64, err error) {
+	var statuses []struct {
+		Endpoint string `json:"Endpoint"`
+		Status   struct {
+			RaftIndex        int64 `json:"raftIndex"`
+			RaftAppliedIndex int64 `json:"raftAppliedIndex"`
+		} `json:"Status"`
+	}
+	if err := json.Unmarshal([]byte(output), &statuses); err != nil {
+		return 0, 0, errors.Wrap(errors.WithStack(err), "parse etcd endpoint status failed")
+	}
+
+	for _, s := range statuses {
+		if s.Status.RaftIndex > leaderIndex {
+			leaderIndex = s.Status.RaftIndex
+		}
+		if strings.Contains(s.Endpoint, learnerAddr) {
+			learnerIndex = s.Status.RaftAppliedIndex
+		}
+	}
+	return leaderIndex, learnerIndex, nil
+}
+
+// PromoteLearner promotes a caught-up learner to a full voting member once JoinMember has
+// confirmed its raft log is within raftIndexDelta of the leader.
+type PromoteLearner struct {
+	common.KubeAction
+}
+
+func (p *PromoteLearner) Execute(runtime connector.Runtime) error {
+	host := runtime.RemoteHost()
+	etcdName, ok := host.GetCache().GetMustString(common.ETCDName)
+	if !ok {
+		return errors.New("get etcd node status by host label failed")
+	}
+
+	v, ok := p.PipelineCache.Get(common.ETCDCluster)
+	if !ok {
 		return errors.New("get etcd cluster status by pipeline cache failed")
 	}
+	cluster := v.(*EtcdCluster)
+
+	memberID, err := memberIDByName(runtime, cluster, etcdName)
+	if err != nil {
+		return errors.Wrap(errors.WithStack(err), "find etcd learner member id failed")
+	}
+
+	promoteCmd := fmt.Sprintf("export ETCDCTL_API=3;"+
+		"export ETCDCTL_CERT='/etc/ssl/etcd/ssl/admin-%s.pem';"+
+		"export ETCDCTL_KEY='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
+		"export ETCDCTL_CACERT='/etc/ssl/etcd/ssl/ca.pem';"+
+		"%s/etcdctl --endpoints=%s member promote %s",
+		host.GetName(), host.GetName(), common.BinDir, cluster.accessAddresses, memberID)
+	if _, err := runtime.GetRunner().SudoCmd(promoteCmd, true); err != nil {
+		return errors.Wrap(errors.WithStack(err), "promote etcd learner failed")
+	}
 	return nil
 }
 
+// memberIDByName looks up a member's hex ID from `member list --write-out=json` by matching its
+// recorded name.
+func memberIDByName(runtime connector.Runtime, cluster *EtcdCluster, etcdName string) (string, error) {
+	host := runtime.RemoteHost()
+	listCmd := fmt.Sprintf("export ETCDCTL_API=3;"+
+		"export ETCDCTL_CERT='/etc/ssl/etcd/ssl/admin-%s.pem';"+
+		"export ETCDCTL_KEY='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
+		"export ETCDCTL_CACERT='/etc/ssl/etcd/ssl/ca.pem';"+
+		"%s/etcdctl --endpoints=%s member list --write-out=json",
+		host.GetName(), host.GetName(), common.BinDir, cluster.accessAddresses)
+
+	out, err := runtime.GetRunner().SudoCmd(listCmd, false)
+	if err != nil {
+		return "", err
+	}
+
+	var list struct {
+		Members []struct {
+			ID   uint64 `json:"ID"`
+			Name string `json:"name"`
+		} `json:"members"`
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return "", errors.Wrap(errors.WithStack(err), "parse etcd member list failed")
+	}
+
+	for _, m := range list.Members {
+		if m.Name == etcdName {
+			return strconv.FormatUint(m.ID, 16), nil
+		}
+	}
+	return "", errors.Errorf("member %s not found in member list", etcdName)
+}
+
 type CheckMember struct {
 	common.KubeAction
 }
@@ -396,11 +628,14 @@ func (c *CheckMember) Execute(runtime connector.Runtime) error {
 			"export ETCDCTL_KEY_FILE='/etc/ssl/etcd/ssl/admin-%s-key.pem';"+
 			"export ETCDCTL_CA_FILE='/etc/ssl/etcd/ssl/ca.pem';"+
 			"%s/etcdctl --no-sync --endpoints=%s member list", host.GetName(), host.GetName(), common.BinDir, cluster.accessAddresses)
-		memberList, err := runtime.GetRunner().SudoCmd(checkMemberCmd, true)
+		wantAddr := fmt.Sprintf("https://%s:2379", host.GetInternalAddress())
+
+		_, err := healthpoll.Retry(healthpoll.DefaultAttempts, healthpoll.DefaultInterval, func() (string, error) {
+			return runtime.GetRunner().SudoCmd(checkMemberCmd, true)
+		}, func(output string) bool {
+			return strings.Contains(output, wantAddr)
+		})
 		if err != nil {
-			return errors.Wrap(errors.WithStack(err), "list etcd member failed")
-		}
-		if !strings.Contains(memberList, fmt.Sprintf("https://%s:2379", host.GetInternalAddress())) {
 			return errors.Wrap(errors.WithStack(err), "add etcd member failed")
 		}
 	} else {
@@ -414,6 +649,10 @@ type RestartETCD struct {
 }
 
 func (r *RestartETCD) Execute(runtime connector.Runtime) error {
+	if handled, err := restartForBackend(r.KubeConf, runtime); handled {
+		return err
+	}
+
 	if _, err := runtime.GetRunner().SudoCmd("systemctl daemon-reload && systemctl restart etcd && systemctl enable etcd", true); err != nil {
 		return errors.Wrap(errors.WithStack(err), "start etcd failed")
 	}
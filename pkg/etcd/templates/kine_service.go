@@ -0,0 +1,40 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package templates
+
+import "text/template"
+
+// KineService renders the systemd unit InstallKine installs on a control-plane host, running
+// kine against the configured SQL DSN and exposing the etcd gRPC surface on 2379 over TLS.
+var KineService = template.Must(template.New("kine.service").Parse(
+	`[Unit]
+Description=kine
+After=network.target
+
+[Service]
+Type=simple
+ExecStart=/usr/local/bin/kine \
+  --endpoint="{{ .DSN }}" \
+  --listen-address=0.0.0.0:2379 \
+  --server-cert-file={{ .CertFile }} \
+  --server-key-file={{ .KeyFile }}
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`))
@@ -0,0 +1,34 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package templates
+
+import "text/template"
+
+// EtcdSnapshotMeta renders the companion metadata JSON file written next to every etcd
+// snapshot taken by SnapshotETCD.
+var EtcdSnapshotMeta = template.Must(template.New("snapshot-meta.json").Parse(
+	`{
+  "clusterID": "{{ .ClusterID }}",
+  "memberList": [{{ range $i, $m := .MemberList }}{{ if $i }}, {{ end }}"{{ $m }}"{{ end }}],
+  "initialCluster": "{{ .InitialCluster }}",
+  "kubernetesVersion": "{{ .KubernetesVer }}",
+  "kubekeyVersion": "{{ .KubeKeyVer }}",
+  "snapshotFile": "{{ .SnapshotFile }}",
+  "snapshotSha256": "{{ .SnapshotSHA256 }}",
+  "creationTimeUtc": "{{ .CreationTimeUTC }}"
+}
+`))
@@ -0,0 +1,72 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package pipelines
+
+import (
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/connector"
+	"github.com/kubesphere/kubekey/pkg/core/module"
+	"github.com/kubesphere/kubekey/pkg/core/pipeline"
+	"github.com/kubesphere/kubekey/pkg/etcd"
+)
+
+// certHosts is every host generateCertsFiles issues a cert for: etcd members plus masters (which
+// also hold node certs), matching the set SyncCertsFile needs to reach.
+func certHosts(runtime *common.KubeRuntime) []connector.Host {
+	return append(runtime.GetHostsByRole(common.ETCD), runtime.GetHostsByRole(common.Master)...)
+}
+
+// NewRenewETCDCertsPipeline backs `kk certs renew --etcd`: it regenerates any etcd cert within
+// its renewal window on the bootstrap host, redistributes the full cert set to every host, then
+// restarts etcd one member at a time, gating each restart on the member coming back healthy.
+func NewRenewETCDCertsPipeline(runtime *common.KubeRuntime) error {
+	m := []module.Module{
+		&module.BaseTaskModule{
+			Tasks: []module.Task{
+				{Name: "RenewETCDCerts", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.RenewCerts{}},
+				{Name: "SyncETCDCertsFile", Hosts: certHosts(runtime), Action: &etcd.SyncCertsFile{}},
+				{Name: "RestartETCDRolling", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.RestartETCDRolling{}},
+			},
+		},
+	}
+
+	p := pipeline.Pipeline{
+		Name:    "RenewETCDCertsPipeline",
+		Modules: m,
+		Runtime: runtime,
+	}
+	return p.Start()
+}
+
+// NewCheckETCDCertExpirationPipeline backs `kk certs check-expiration`: it reports the remaining
+// validity of every etcd cert on every etcd host, without changing anything.
+func NewCheckETCDCertExpirationPipeline(runtime *common.KubeRuntime) error {
+	m := []module.Module{
+		&module.BaseTaskModule{
+			Tasks: []module.Task{
+				{Name: "CheckETCDCertExpiration", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.CheckExpiration{}},
+			},
+		},
+	}
+
+	p := pipeline.Pipeline{
+		Name:    "CheckETCDCertExpirationPipeline",
+		Modules: m,
+		Runtime: runtime,
+	}
+	return p.Start()
+}
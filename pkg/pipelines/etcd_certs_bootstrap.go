@@ -0,0 +1,61 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package pipelines
+
+import (
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/connector"
+	"github.com/kubesphere/kubekey/pkg/core/module"
+	"github.com/kubesphere/kubekey/pkg/core/pipeline"
+	"github.com/kubesphere/kubekey/pkg/etcd"
+)
+
+// bootstrapHost is the single node CertSigner/SignCSRs run on: the one place the CA (and its
+// private key) ever exists. It's the first etcd-role host, falling back to the first master
+// for kine/external clusters that have none.
+func bootstrapHost(runtime *common.KubeRuntime) []connector.Host {
+	if hosts := runtime.GetHostsByRole(common.ETCD); len(hosts) > 0 {
+		return hosts[:1]
+	}
+	return runtime.GetHostsByRole(common.Master)[:1]
+}
+
+// NewBootstrapETCDCertsPipeline generates and distributes every etcd cert without ever moving a
+// private key across the network: CertSigner only ever produces the CA, every host generates
+// its own key locally via GenerateHostKeyAndCSR and submits a CSR, and DistributeSignedCerts
+// only ships back the signed cert plus the CA's public cert.
+func NewBootstrapETCDCertsPipeline(runtime *common.KubeRuntime) error {
+	hosts := certHosts(runtime)
+	m := []module.Module{
+		&module.BaseTaskModule{
+			Tasks: []module.Task{
+				{Name: "CertSigner", Hosts: bootstrapHost(runtime), Action: &etcd.CertSigner{}},
+				{Name: "GenerateHostKeyAndCSR", Hosts: hosts, Action: &etcd.GenerateHostKeyAndCSR{}},
+				{Name: "FetchCSRs", Hosts: hosts, Action: &etcd.FetchCSRs{}},
+				{Name: "SignCSRs", Hosts: bootstrapHost(runtime), Action: &etcd.SignCSRs{}},
+				{Name: "DistributeSignedCerts", Hosts: hosts, Action: &etcd.DistributeSignedCerts{}},
+			},
+		},
+	}
+
+	p := pipeline.Pipeline{
+		Name:    "BootstrapETCDCertsPipeline",
+		Modules: m,
+		Runtime: runtime,
+	}
+	return p.Start()
+}
@@ -0,0 +1,48 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package pipelines
+
+import (
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/module"
+	"github.com/kubesphere/kubekey/pkg/core/pipeline"
+	"github.com/kubesphere/kubekey/pkg/etcd"
+)
+
+// NewAddETCDMemberPipeline adds every host in runtime's common.ETCD role to the cluster as a
+// learner, waits for each to catch up on the raft log, and promotes it to a full voting member.
+// JoinMember and PromoteLearner run against the same host set because each learner is promoted
+// only after it has individually caught up, never before the whole set has joined.
+func NewAddETCDMemberPipeline(runtime *common.KubeRuntime) error {
+	m := []module.Module{
+		&module.BaseTaskModule{
+			Tasks: []module.Task{
+				{Name: "GetETCDStatus", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.GetStatus{}},
+				{Name: "GenerateAccessAddress", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.GenerateAccessAddress{}},
+				{Name: "JoinMember", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.JoinMember{}},
+				{Name: "PromoteLearner", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.PromoteLearner{}},
+			},
+		},
+	}
+
+	p := pipeline.Pipeline{
+		Name:    "AddETCDMemberPipeline",
+		Modules: m,
+		Runtime: runtime,
+	}
+	return p.Start()
+}
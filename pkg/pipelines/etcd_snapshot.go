@@ -0,0 +1,123 @@
+/*
+ Copyright 2021 The KubeSphere Authors.
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package pipelines
+
+import (
+	"github.com/kubesphere/kubekey/pkg/common"
+	"github.com/kubesphere/kubekey/pkg/core/connector"
+	"github.com/kubesphere/kubekey/pkg/core/module"
+	"github.com/kubesphere/kubekey/pkg/core/pipeline"
+	"github.com/kubesphere/kubekey/pkg/etcd"
+	"github.com/pkg/errors"
+)
+
+// NewSnapshotETCDPipeline backs `kk etcd snapshot`: it takes a v3-API snapshot on every etcd
+// member and prunes old snapshots according to the configured retention.
+func NewSnapshotETCDPipeline(runtime *common.KubeRuntime) error {
+	m := []module.Module{
+		&module.BaseTaskModule{
+			Tasks: []module.Task{
+				{Name: "GetETCDStatus", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.GetStatus{}},
+				{Name: "GenerateAccessAddress", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.GenerateAccessAddress{}},
+				{Name: "SnapshotETCD", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.SnapshotETCD{}},
+				{Name: "PruneSnapshots", Hosts: runtime.GetHostsByRole(common.ETCD), Action: &etcd.PruneSnapshots{}},
+			},
+		},
+	}
+
+	p := pipeline.Pipeline{
+		Name:    "SnapshotETCDPipeline",
+		Modules: m,
+		Runtime: runtime,
+	}
+	return p.Start()
+}
+
+// NewStatusETCDPipeline backs `kk etcd status`: it runs the v3-API health check across every
+// member and prints the resulting report.
+func NewStatusETCDPipeline(runtime *common.KubeRuntime) error {
+	etcdHosts := runtime.GetHostsByRole(common.ETCD)
+	if len(etcdHosts) == 0 {
+		// kine/external backends have no common.ETCD-role host; there's no binary etcd member
+		// to probe here (see healthCheckForBackend for their own health check path).
+		return errors.New("kk etcd status requires at least one host with the etcd role")
+	}
+	probeHost := etcdHosts[:1]
+
+	m := []module.Module{
+		&module.BaseTaskModule{
+			Tasks: []module.Task{
+				{Name: "GetETCDStatus", Hosts: etcdHosts, Action: &etcd.GetStatus{}},
+				{Name: "GenerateAccessAddress", Hosts: etcdHosts, Action: &etcd.GenerateAccessAddress{}},
+				{Name: "HealthCheckV3", Hosts: probeHost, Action: &etcd.HealthCheckV3{}},
+				{Name: "PrintHealthReport", Hosts: probeHost, Action: &etcd.PrintHealthReport{}},
+			},
+		},
+	}
+
+	p := pipeline.Pipeline{
+		Name:    "StatusETCDPipeline",
+		Modules: m,
+		Runtime: runtime,
+	}
+	return p.Start()
+}
+
+// NewRestoreETCDPipeline backs `kk etcd restore <snapshot-name>`: only the host named in the
+// snapshot's file name is guaranteed to have it on local disk (or can pull it from S3), so that
+// host alone restores it and bootstraps a fresh single-node cluster. Every other etcd-role host
+// wipes its own data directory and rejoins that cluster as a learner, the same way a newly added
+// member would.
+func NewRestoreETCDPipeline(runtime *common.KubeRuntime, snapshotName string) error {
+	etcdHosts := runtime.GetHostsByRole(common.ETCD)
+	originName, ok := etcd.SnapshotOriginHost(snapshotName)
+	if !ok {
+		return errors.Errorf("snapshot name %q is not in the expected snapshot-<host>-<timestamp>.db form", snapshotName)
+	}
+
+	var originHosts, rejoinHosts []connector.Host
+	for _, h := range etcdHosts {
+		if h.GetName() == originName {
+			originHosts = append(originHosts, h)
+		} else {
+			rejoinHosts = append(rejoinHosts, h)
+		}
+	}
+	if len(originHosts) == 0 {
+		return errors.Errorf("snapshot origin host %q is not a configured etcd host", originName)
+	}
+
+	m := []module.Module{
+		&module.BaseTaskModule{
+			Tasks: []module.Task{
+				{Name: "GetETCDStatus", Hosts: etcdHosts, Action: &etcd.GetStatus{}},
+				{Name: "GenerateAccessAddress", Hosts: etcdHosts, Action: &etcd.GenerateAccessAddress{}},
+				{Name: "RestoreETCD", Hosts: originHosts, Action: &etcd.RestoreETCD{SnapshotName: snapshotName}},
+				{Name: "WipeMemberData", Hosts: rejoinHosts, Action: &etcd.WipeMemberData{}},
+				{Name: "JoinMember", Hosts: rejoinHosts, Action: &etcd.JoinMember{}},
+				{Name: "PromoteLearner", Hosts: rejoinHosts, Action: &etcd.PromoteLearner{}},
+			},
+		},
+	}
+
+	p := pipeline.Pipeline{
+		Name:    "RestoreETCDPipeline",
+		Modules: m,
+		Runtime: runtime,
+	}
+	return p.Start()
+}